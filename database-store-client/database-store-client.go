@@ -5,8 +5,11 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
-	_ "github.com/bmizerany/pq"
+	"github.com/bmizerany/pq"
+	"github.com/sirupsen/logrus"
 	"os"
+	"strings"
+	"time"
 )
 
 var (
@@ -14,29 +17,135 @@ var (
 	database        *sql.DB
 	eventInsertion  *sql.Stmt
 	prefixInsertion *sql.Stmt
+	log             = logrus.New()
+	batchSize       = flag.Int("batch-size", 1000, "Number of pending inserts to buffer before committing, even without a cache response/end-of-data boundary")
+	pending         batch
 )
 
+// pendingEvent and pendingPrefix are the rows buffered by store()
+// between transaction commits.
+type pendingEvent struct {
+	description string
+	serial      *uint32
+}
+
+type pendingPrefix struct {
+	announce  bool
+	cidr      string
+	maxLength uint8
+	serial    *uint32
+}
+
+// batch accumulates rows across PDUs so that an initial cache dump of
+// hundreds of thousands of ROAs does not open one transaction per
+// prefix.
+type batch struct {
+	events   []pendingEvent
+	prefixes []pendingPrefix
+}
+
+func (b *batch) empty() bool {
+	return len(b.events) == 0 && len(b.prefixes) == 0
+}
+
+func (b *batch) size() int {
+	return len(b.events) + len(b.prefixes)
+}
+
+// store buffers the event and flushes the pending batch to PostgreSQL
+// at a cACHERESPONSE/eNDOFDATA boundary, or once it reaches
+// *batchSize rows.
 func store(event rtr.Event, state rtr.Client) {
+	if event.NewPrefix == nil {
+		pending.events = append(pending.events, pendingEvent{event.Description, state.SerialNo})
+	} else {
+		cidr := fmt.Sprintf("%s/%d", event.NewPrefix.Address, event.NewPrefix.Length)
+		pending.prefixes = append(pending.prefixes, pendingPrefix{event.NewPrefix.Announcement, cidr, event.NewPrefix.MaxLength, state.SerialNo})
+	}
+	boundary := strings.HasPrefix(event.Description, "Cache Response") || event.Description == "(Temporary) End of Data"
+	if boundary || pending.size() >= *batchSize {
+		flush()
+	}
+}
+
+// flush commits the pending batch in a single transaction, retrying
+// on PostgreSQL serialization failures, and clears the batch on
+// success. Non-retryable errors are logged and the batch is dropped
+// rather than calling os.Exit, so a single bad row doesn't bring down
+// a long-running rtrclientpg.
+func flush() {
+	if pending.empty() {
+		return
+	}
+	batchLen := pending.size()
+	err := withSerializationRetry(5, func() error {
+		return inTx(func(tx *sql.Tx) error {
+			events := tx.Stmt(eventInsertion)
+			prefixes := tx.Stmt(prefixInsertion)
+			for _, e := range pending.events {
+				if _, err := events.Exec(remote, e.description, e.serial); err != nil {
+					return err
+				}
+			}
+			for _, p := range pending.prefixes {
+				if _, err := prefixes.Exec(p.announce, p.cidr, p.maxLength, p.serial); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.WithFields(logrus.Fields{"remote": remote, "rows": batchLen}).Errorf("Cannot commit batch, dropping it: %s", err)
+	}
+	pending = batch{}
+}
+
+func inTx(fn func(*sql.Tx) error) error {
 	transact, err := database.Begin()
 	if err != nil {
-		fmt.Printf("Cannot start a transaction: %s\n", err)
-		os.Exit(1)
+		return err
 	}
-	if event.NewPrefix == nil {
-		_, err = eventInsertion.Exec(remote, event.Description, state.SerialNo)
-		if err != nil {
-			fmt.Printf("Cannot execute event insertion: %s\n", err)
-			os.Exit(1)
+	if err := fn(transact); err != nil {
+		transact.Rollback()
+		return err
+	}
+	return transact.Commit()
+}
+
+// isSerializationFailure reports whether err is a PostgreSQL
+// serialization_failure (40001) or deadlock_detected (40P01), the two
+// SQLSTATEs that are safe to retry after a backoff.
+func isSerializationFailure(err error) bool {
+	if pqErr, ok := err.(pq.PGError); ok {
+		switch pqErr.Get('C') {
+		case "40001", "40P01":
+			return true
 		}
-	} else {
-		cidr := fmt.Sprintf("%s/%d", event.NewPrefix.Address, event.NewPrefix.Length)
-		_, err = prefixInsertion.Exec(event.NewPrefix.Announcement, cidr, event.NewPrefix.MaxLength, state.SerialNo)
-		if err != nil {
-			fmt.Printf("Cannot execute prefix insertion: %s\n", err)
-			os.Exit(1)
+	}
+	return false
+}
+
+// withSerializationRetry runs fn, retrying with capped exponential
+// backoff while it fails with a retryable SQLSTATE. Any other error
+// is returned immediately.
+func withSerializationRetry(maxAttempts int, fn func() error) (err error) {
+	delay := 100 * time.Millisecond
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !isSerializationFailure(err) {
+			return err
+		}
+		log.WithFields(logrus.Fields{"attempt": attempt}).Warnf("Retrying transaction after serialization error: %s", err)
+		time.Sleep(delay)
+		if delay < 5*time.Second {
+			delay *= 2
 		}
 	}
-	transact.Commit()
+	return err
 }
 
 func main() {
@@ -45,36 +154,37 @@ func main() {
 	)
 	flag.Parse()
 	if flag.NArg() != 2 {
-		fmt.Printf("Usage: rtrclient server port\n")
+		log.Error("Usage: rtrclient server port")
 		os.Exit(1)
 	}
 	server := flag.Arg(0)
 	port := flag.Arg(1)
-	remote = server + ":" + port
+	remote = rtr.JoinHostPort(server, port)
 	database, err = sql.Open("postgres", "host=/var/run/postgresql dbname=essais sslmode=disable")
 	if err != nil { // Useless, Open never fails https://github.com/bmizerany/pq/issues/63
-		fmt.Printf("Cannot connnect to PostgreSQL: %s\n", err)
+		log.Errorf("Cannot connnect to PostgreSQL: %s", err)
 		os.Exit(1)
 	}
 	_, err = database.Query("SELECT true")
 	if err != nil {
-		fmt.Printf("Cannot run test query: %s\n", err)
+		log.Errorf("Cannot run test query: %s", err)
 		os.Exit(1)
 	}
 	eventInsertion, err = database.Prepare("INSERT INTO Events (time, server, event, serialno) VALUES (now(), $1, $2, $3)")
 	if err != nil {
-		fmt.Printf("Cannot prepare event insertion: %s\n", err)
+		log.Errorf("Cannot prepare event insertion: %s", err)
 		os.Exit(1)
 	}
 	prefixInsertion, err = database.Prepare("INSERT INTO Prefixes (time, announce, prefix, maxlength, serialno) VALUES (now(), $1, $2, $3, $4)")
 	if err != nil {
-		fmt.Printf("Cannot prepare prefix insertion: %s\n", err)
+		log.Errorf("Cannot prepare prefix insertion: %s", err)
 		os.Exit(1)
 	}
 	rtrClient := &rtr.Client{}
-	err = rtrClient.Dial(remote, store)
+	err = rtrClient.Dial(remote, store, 1)
+	flush()
 	if err != nil {
-		fmt.Printf("Problem with RTR server: %s\n", err)
+		log.WithFields(logrus.Fields{"remote": remote}).Errorf("Problem with RTR server: %s", err)
 		os.Exit(1)
 	}
 }
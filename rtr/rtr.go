@@ -4,14 +4,14 @@ the client, and mostly done for surveys or monitoring, not to be
 included in a real router.
 
 Example of use:
-        func display(event rtr.Event, state rtr.Client) {
-                if event.NewPrefix != nil {
-                    fmt.Printf("Got %s\n", even.NewPrefix.Address)
-                }
-        }
-
-        rtrClient := &rtr.Client{}
-	err := rtrClient.Dial("rpki-validator.realmv6.org:8282", display, 0)
+	func display(event rtr.Event, state rtr.Client) {
+		if event.NewPrefix != nil {
+		    fmt.Printf("Got %s\n", event.NewPrefix.Address)
+		}
+	}
+
+	rtrClient := &rtr.Client{}
+	err := rtrClient.Dial(rtr.JoinHostPort("rpki-validator.realmv6.org", "8282"), display, 0)
 	if err != nil {
 		fmt.Printf("Problem with RTR server: %s\n", err)
 		os.Exit(1)
@@ -26,11 +26,17 @@ Stephane Bortzmeyer <bortzmeyer@nic.fr>
 package rtr
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -46,25 +52,216 @@ const (
 	cACHERESET  = 8
 	rOUTERKEY   = 9
 	eRRORREPORT = 10
+	aSPA        = 11 // RFC 8210bis / the ASPA RTR PDU, protocol version 2 only
 	// Sizes
 	hEADERSIZE      = 8
 	sERIALQUERYSIZE = 12
 	rESETQUERYSIZE  = 8
+	sKISIZE         = 20 // Subject Key Identifier, in a Router Key PDU
 	mAXSIZE         = 65536
 	// Misc
 	sLEEPTIME = 40 * time.Minute // The RFC says it must be < 1 hour but some RPKI caches reply with timeout if you don't poll them every five minutes :-(
-)
 
-var (
-	protocolVersion byte
-	debug           bool = false // TODO export it for the clients
+	// RFC 8210 §5.10, error code in an Error Report PDU meaning the
+	// server does not support the protocol version we used.
+	unsupportedProtocolVersion = 4
 )
 
+// errUnsupportedVersion is a sentinel returned internally by readData
+// (via comm) when the cache rejected our protocol version and we
+// already downgraded client.version: dialOnce redials immediately
+// instead of surfacing the error to the caller.
+var errUnsupportedVersion = errors.New("unsupported protocol version, downgrading and redialing")
+
+// Logger is the logging interface used throughout the package to
+// report protocol events (PDU parse failures, unexpected session
+// IDs, reconnects, etc). It lets embedders route RTR events to their
+// own log sinks instead of stdout. A Client with no Logger set uses
+// the one returned by newDefaultLogger, a thin wrapper around a
+// standalone logrus.Logger writing to stderr.
+type Logger interface {
+	Debug(fields logrus.Fields, message string)
+	Info(fields logrus.Fields, message string)
+	Warn(fields logrus.Fields, message string)
+	Error(fields logrus.Fields, message string)
+}
+
+// logrusLogger is the default Logger implementation, backed by logrus.
+type logrusLogger struct {
+	entry *logrus.Logger
+}
+
+func newDefaultLogger() Logger {
+	return &logrusLogger{entry: logrus.New()}
+}
+
+func (l *logrusLogger) Debug(fields logrus.Fields, message string) {
+	l.entry.WithFields(fields).Debug(message)
+}
+
+func (l *logrusLogger) Info(fields logrus.Fields, message string) {
+	l.entry.WithFields(fields).Info(message)
+}
+
+func (l *logrusLogger) Warn(fields logrus.Fields, message string) {
+	l.entry.WithFields(fields).Warn(message)
+}
+
+func (l *logrusLogger) Error(fields logrus.Fields, message string) {
+	l.entry.WithFields(fields).Error(message)
+}
+
+// DialOption configures Dial and DialContext: the reconnection
+// backoff behavior of DialContext, and the transport (plain TCP or
+// TLS) used by both. Use one of the With* functions below to override
+// a default.
+type DialOption func(*dialOptions)
+
+// dialOptions holds the exponential-backoff-with-jitter parameters
+// for DialContext: each consecutive failure multiplies the delay by
+// Factor, up to MaxDelay, and the result is jittered by ±Jitter (the
+// first reconnection waits exactly BaseDelay, without jitter). It
+// also holds the transport to use for the underlying connection.
+type dialOptions struct {
+	BaseDelay  time.Duration
+	Factor     float64
+	MaxDelay   time.Duration
+	Jitter     float64
+	MaxRetries int // 0 means retry forever
+	// Transport is the *tls.Config to dial with. A nil Transport (the
+	// default) dials plain TCP.
+	Transport *tls.Config
+}
+
+func defaultDialOptions() dialOptions {
+	return dialOptions{
+		BaseDelay:  1 * time.Second,
+		Factor:     1.6,
+		MaxDelay:   120 * time.Second,
+		Jitter:     0.2,
+		MaxRetries: 0,
+		Transport:  nil,
+	}
+}
+
+// WithBaseDelay sets the delay before the first retry.
+func WithBaseDelay(delay time.Duration) DialOption {
+	return func(o *dialOptions) { o.BaseDelay = delay }
+}
+
+// WithFactor sets the multiplier applied to the delay after each
+// consecutive failure.
+func WithFactor(factor float64) DialOption {
+	return func(o *dialOptions) { o.Factor = factor }
+}
+
+// WithMaxDelay caps the delay between retries.
+func WithMaxDelay(delay time.Duration) DialOption {
+	return func(o *dialOptions) { o.MaxDelay = delay }
+}
+
+// WithJitter sets the fraction of the computed delay to randomize,
+// uniformly, in both directions.
+func WithJitter(jitter float64) DialOption {
+	return func(o *dialOptions) { o.Jitter = jitter }
+}
+
+// WithMaxRetries caps the number of consecutive reconnection
+// attempts. 0 (the default) means retry forever.
+func WithMaxRetries(retries int) DialOption {
+	return func(o *dialOptions) { o.MaxRetries = retries }
+}
+
+// WithTLS makes Dial/DialContext connect over TLS instead of plain
+// TCP, using config (the caller's CA bundle, client certificate, and
+// ServerName). RFC 6810/8210 permit running RTR over TLS, TCP-MD5, or
+// TCP-AO in addition to plain TCP; TCP-MD5/TCP-AO are negotiated by
+// the kernel's TCP stack below net.Dial and are out of this package's
+// reach, but TLS is just a different Dialer.
+func WithTLS(config *tls.Config) DialOption {
+	return func(o *dialOptions) { o.Transport = config }
+}
+
+// backoff computes the delay before the (retries+1)th reconnection
+// attempt, that is, after retries reconnections have already failed.
+// backoff(0) is BaseDelay, unjittered.
+func (o *dialOptions) backoff(retries int) time.Duration {
+	if retries == 0 {
+		return o.BaseDelay
+	}
+	delay, max := float64(o.BaseDelay), float64(o.MaxDelay)
+	for delay < max && retries > 0 {
+		delay *= o.Factor
+		retries--
+	}
+	if delay > max {
+		delay = max
+	}
+	delay += delay * o.Jitter * (2*rand.Float64() - 1)
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
 // A connection to the validating RPKI cache (RFC 6480)
 type Client struct {
 	connection net.Conn
 	SessionID  *uint16
 	SerialNo   *uint32
+	// Logger receives structured events for this connection. If nil,
+	// Dial installs a logrus-backed default that writes to stderr.
+	Logger Logger
+	remote string
+	// sawFullCycle is set once a cACHERESPONSE followed by an
+	// eNDOFDATA has been seen on the current connection, so
+	// DialContext knows a session was actually established and can
+	// reset its retry counter.
+	sawFullCycle bool
+	// cancel stops the internal context shared by readData and loop
+	// across reconnects. Close/Shutdown call it to request a clean
+	// stop; it is nil until Dial or DialContext has been called.
+	cancel context.CancelFunc
+	// wg tracks the readData and loop goroutines so Wait can block
+	// until both have exited. It is a pointer, not a plain
+	// sync.WaitGroup, because Client is passed by value to the
+	// action(Event, Client) callback on every event; embedding a lock
+	// directly would make go vet flag (and every dispatch silently
+	// copy) that lock.
+	wg *sync.WaitGroup
+	// version is this connection's negotiated RTR protocol version.
+	// It lives on the Client, not as a package global, so two
+	// concurrent Client instances talking to different caches (or at
+	// different negotiated versions) don't clash. negotiated tracks
+	// whether version has been initialized yet from the version
+	// argument passed to Dial/DialContext; once set, it is only ever
+	// changed by the version-downgrade state machine in readData.
+	version    byte
+	negotiated bool
+	// tlsConfig, when non-nil, makes connectAndServe dial over TLS
+	// instead of plain TCP. Set from DialOption WithTLS by Dial or
+	// DialContext.
+	tlsConfig *tls.Config
+}
+
+// logger returns client.Logger, lazily defaulting it so callers who
+// build a Client directly (without going through Dial yet) never hit
+// a nil interface.
+func (client *Client) logger() Logger {
+	if client.Logger == nil {
+		client.Logger = newDefaultLogger()
+	}
+	return client.Logger
+}
+
+// waitGroup returns client.wg, lazily allocating it so a Client built
+// directly (without going through Dial yet) never dereferences a nil
+// pointer.
+func (client *Client) waitGroup() *sync.WaitGroup {
+	if client.wg == nil {
+		client.wg = &sync.WaitGroup{}
+	}
+	return client.wg
 }
 
 // A ROA (RFC 6482) prefix (IPv4 or IPv6)
@@ -76,10 +273,40 @@ type Prefix struct {
 	ASn          uint32
 }
 
-// An interesting event from the cache, typically a new prefix
+// A Router Key (RFC 8210 §5.8), binding a router's Subject Key
+// Identifier to an ASN and its public key, available since protocol
+// version 1.
+type RouterKey struct {
+	Announcement bool
+	SKI          []byte // Subject Key Identifier (20 octets)
+	ASn          uint32
+	SPKI         []byte // Subject Public Key Info, DER-encoded
+}
+
+// An ASPA record (RFC 8210bis), listing the providers a customer ASN
+// is allowed to route through. Available since protocol version 2.
+type ASPA struct {
+	Announcement bool
+	CustomerASN  uint32
+	ProviderASNs []uint32
+}
+
+// An Error Report PDU (RFC 8210 §5.10): a fatal protocol-level error
+// signaled by the cache, as opposed to a transport error.
+type ErrorReport struct {
+	ErrorCode uint16
+	Text      string
+}
+
+// An interesting event from the cache, typically a new prefix. Only
+// one of NewPrefix, RouterKey, ASPA, or ErrorReport is ever non-nil
+// for a given event.
 type Event struct {
 	Description string
-	NewPrefix   *Prefix // nil if if the event is not a new prefix
+	NewPrefix   *Prefix      // nil if the event is not a new prefix
+	RouterKey   *RouterKey   // nil if the event is not a Router Key
+	ASPA        *ASPA        // nil if the event is not an ASPA record
+	ErrorReport *ErrorReport // nil if the event is not an Error Report
 }
 
 func checkLength(comm chan error, ptype byte, length uint, expected uint) (err error) {
@@ -90,7 +317,7 @@ func checkLength(comm chan error, ptype byte, length uint, expected uint) (err e
 	return err
 }
 
-func (client *Client) readData(comm chan error, action func(Event, Client)) (err error) {
+func (client *Client) readData(ctx context.Context, comm chan error, action func(Event, Client)) (err error) {
 	var (
 		buffer []byte
 		total  uint
@@ -101,39 +328,64 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 		for total = 0; total < hEADERSIZE; { // TODO add a timeout, if the TCP session becomes stale?
 			n, err = client.connection.Read(headerbuffer[total:])
 			if err != nil {
-				comm <- errors.New(fmt.Sprintf("Error in TCP Read of RTR header: \"%s\" (got %d bytes)\n", err, n))
+				if ctx.Err() != nil {
+					// Shutdown was requested: the read deadline set by
+					// Close/Shutdown made this Read return early. That's
+					// expected, not a protocol or transport failure.
+					client.logger().Info(logrus.Fields{"remote": client.remote}, "Connection closed for shutdown")
+					comm <- nil
+					return nil
+				}
+				err = errors.New(fmt.Sprintf("Error in TCP Read of RTR header: \"%s\" (got %d bytes)\n", err, n))
+				client.logger().Error(logrus.Fields{"remote": client.remote}, err.Error())
+				comm <- err
 				break
 			}
 			total += uint(n)
 		}
 		if total < hEADERSIZE {
-			comm <- errors.New(fmt.Sprintf("Short in TCP Read of RTR header: got %d bytes, expected %d\n", total, hEADERSIZE))
+			err = errors.New(fmt.Sprintf("Short in TCP Read of RTR header: got %d bytes, expected %d\n", total, hEADERSIZE))
+			client.logger().Error(logrus.Fields{"remote": client.remote}, err.Error())
+			comm <- err
 			break
 		}
-		if headerbuffer[0] != protocolVersion {
-			comm <- errors.New(fmt.Sprintf("Invalid protocol %d\n", headerbuffer[0]))
+		pduType := headerbuffer[1]
+		// An Error Report PDU telling us our version is unsupported is,
+		// by definition, formatted in the cache's own (lower) version,
+		// not ours: the version check below must not reject it before
+		// the eRRORREPORT case gets a chance to read it and downgrade.
+		if headerbuffer[0] != client.version && pduType != eRRORREPORT {
+			err = errors.New(fmt.Sprintf("Invalid protocol %d\n", headerbuffer[0]))
+			client.logger().Error(logrus.Fields{"remote": client.remote}, err.Error())
+			comm <- err
 			break
 		}
-		pduType := headerbuffer[1]
 		length := uint(binary.BigEndian.Uint32(headerbuffer[4:8]))
 		if length-hEADERSIZE > 0 {
 			buffer = make([]byte, length-hEADERSIZE)
 			for total = 0; total < length-hEADERSIZE; {
 				n, err = client.connection.Read(buffer[total:])
 				if err != nil {
-					comm <- errors.New(fmt.Sprintf("Error in TCP Read of data: %s\n", err))
+					if ctx.Err() != nil {
+						client.logger().Info(logrus.Fields{"remote": client.remote}, "Connection closed for shutdown")
+						comm <- nil
+						return nil
+					}
+					err = errors.New(fmt.Sprintf("Error in TCP Read of data: %s\n", err))
+					client.logger().Error(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+					comm <- err
 					break
 				}
 				total += uint(n)
 			}
 			if total < length-hEADERSIZE {
-				comm <- errors.New(fmt.Sprintf("Short in TCP Read of data: got %d bytes, expected %d\n", total+hEADERSIZE, length))
+				err = errors.New(fmt.Sprintf("Short in TCP Read of data: got %d bytes, expected %d\n", total+hEADERSIZE, length))
+				client.logger().Error(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+				comm <- err
 				break
 			}
 		}
-		if debug {
-			fmt.Printf("DEBUG: PDU %d\n", buffer)
-		}
+		client.logger().Debug(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, fmt.Sprintf("PDU %d", buffer))
 		switch pduType {
 		case sERIALNOTIFY:
 			err := checkLength(comm, pduType, length, 12)
@@ -143,7 +395,9 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 			sessionID := binary.BigEndian.Uint16(headerbuffer[2:4])
 			if client.SessionID != nil {
 				if *client.SessionID != sessionID {
-					comm <- errors.New(fmt.Sprintf("Serial Notify received with a wrong session ID (%d, expecting %d); cache restarted?", sessionID, *client.SessionID))
+					err = errors.New(fmt.Sprintf("Serial Notify received with a wrong session ID (%d, expecting %d); cache restarted?", sessionID, *client.SessionID))
+					client.logger().Warn(logrus.Fields{"remote": client.remote, "pdu_type": pduType, "session_id": sessionID}, err.Error())
+					comm <- err
 					break
 				}
 			} else {
@@ -151,7 +405,8 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 				*client.SessionID = sessionID
 			}
 			serialNo := binary.BigEndian.Uint32(buffer[0:4])
-			action(Event{fmt.Sprintf("Serial Notify #%d -> #%d", *client.SerialNo, serialNo), nil}, *client)
+			client.logger().Info(logrus.Fields{"remote": client.remote, "pdu_type": pduType, "session_id": sessionID, "serial": serialNo}, "Serial Notify")
+			action(Event{Description: fmt.Sprintf("Serial Notify #%d -> #%d", *client.SerialNo, serialNo)}, *client)
 			if client.SerialNo == nil { // Should not happen but let's be robust
 				client.resetQuery()
 			} else if serialNo != *client.SerialNo {
@@ -165,14 +420,17 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 			sessionID := binary.BigEndian.Uint16(headerbuffer[2:4])
 			if client.SessionID != nil {
 				if *client.SessionID != sessionID {
-					comm <- errors.New(fmt.Sprintf("Cache Response received with a wrong session ID (%d, expecting %d)", sessionID, *client.SessionID))
+					err = errors.New(fmt.Sprintf("Cache Response received with a wrong session ID (%d, expecting %d)", sessionID, *client.SessionID))
+					client.logger().Warn(logrus.Fields{"remote": client.remote, "pdu_type": pduType, "session_id": sessionID}, err.Error())
+					comm <- err
 					break
 				}
 			} else {
 				client.SessionID = new(uint16)
 				*client.SessionID = sessionID
 			}
-			action(Event{fmt.Sprintf("Cache Response, session is %d", *client.SessionID), nil}, *client)
+			client.logger().Info(logrus.Fields{"remote": client.remote, "pdu_type": pduType, "session_id": *client.SessionID}, "Cache Response")
+			action(Event{Description: fmt.Sprintf("Cache Response, session is %d", *client.SessionID)}, *client)
 		case iPv4PREFIX:
 			err := checkLength(comm, pduType, length, 20)
 			if err != nil {
@@ -187,7 +445,7 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 			maxlength := buffer[2]
 			asn := binary.BigEndian.Uint32(buffer[8:12])
 			prefix := Prefix{announcement, net.IP(buffer[4:8]), plength, maxlength, asn}
-			action(Event{"Prefix", &prefix}, *client)
+			action(Event{Description: "Prefix", NewPrefix: &prefix}, *client)
 		case iPv6PREFIX:
 			err := checkLength(comm, pduType, length, 32)
 			if err != nil {
@@ -202,7 +460,7 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 			maxlength := buffer[2]
 			asn := binary.BigEndian.Uint32(buffer[20:24])
 			prefix := Prefix{announcement, net.IP(buffer[4:20]), plength, maxlength, asn}
-			action(Event{"Prefix", &prefix}, *client)
+			action(Event{Description: "Prefix", NewPrefix: &prefix}, *client)
 		case eNDOFDATA:
 			err := checkLength(comm, pduType, length, 12)
 			if err != nil {
@@ -213,8 +471,12 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 				client.SerialNo = new(uint32)
 			}
 			*client.SerialNo = binary.BigEndian.Uint32(buffer[0:4])
+			if client.SessionID != nil {
+				client.sawFullCycle = true
+			}
+			client.logger().Info(logrus.Fields{"remote": client.remote, "pdu_type": pduType, "serial": *client.SerialNo}, "(Temporary) End of Data")
 			// Then, just wait the next read
-			action(Event{"(Temporary) End of Data", nil}, *client)
+			action(Event{Description: "(Temporary) End of Data"}, *client)
 			// TODO: for the next read, check the session ID ?
 		case cACHERESET:
 			err := checkLength(comm, pduType, length, 8)
@@ -222,23 +484,73 @@ func (client *Client) readData(comm chan error, action func(Event, Client)) (err
 				break
 			}
 			// The cache probably restarted or lost its history. Let's restart from the bgeinning
-			action(Event{"Cache reset", nil}, *client)
+			client.logger().Warn(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, "Cache reset")
+			action(Event{Description: "Cache reset"}, *client)
 			client.resetQuery()
 		case rOUTERKEY:
-			if protocolVersion <= 0 {
-				comm <- errors.New(fmt.Sprintf("Invalid Router Key message received for protocol version %d", protocolVersion))
+			if client.version < 1 {
+				err = errors.New(fmt.Sprintf("Router Key PDU received for protocol version %d, which does not support it", client.version))
+				client.logger().Warn(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+				comm <- err
+				break
+			}
+			if length-hEADERSIZE < sKISIZE+4 {
+				err = errors.New(fmt.Sprintf("Router Key PDU too short: %d bytes of data, expected at least %d", length-hEADERSIZE, sKISIZE+4))
+				client.logger().Error(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+				comm <- err
+				break
+			}
+			announcement := (headerbuffer[2] & 0x1) == 1
+			ski := make([]byte, sKISIZE)
+			copy(ski, buffer[0:sKISIZE])
+			asn := binary.BigEndian.Uint32(buffer[sKISIZE : sKISIZE+4])
+			spki := make([]byte, len(buffer)-sKISIZE-4)
+			copy(spki, buffer[sKISIZE+4:])
+			routerKey := RouterKey{announcement, ski, asn, spki}
+			client.logger().Info(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, fmt.Sprintf("Router Key for AS%d", asn))
+			action(Event{Description: "Router Key", RouterKey: &routerKey}, *client)
+		case aSPA:
+			if client.version < 2 {
+				err = errors.New(fmt.Sprintf("ASPA PDU received for protocol version %d, which does not support it", client.version))
+				client.logger().Warn(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+				comm <- err
+				break
+			}
+			if (length-hEADERSIZE) < 4 || (length-hEADERSIZE-4)%4 != 0 {
+				err = errors.New(fmt.Sprintf("Malformed ASPA PDU: %d bytes of data", length-hEADERSIZE))
+				client.logger().Error(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+				comm <- err
 				break
 			}
-			action(Event{"Router Key (ignored)", nil}, *client)
+			announcement := (headerbuffer[2] & 0x1) == 1
+			customerASN := binary.BigEndian.Uint32(buffer[0:4])
+			providers := make([]uint32, (len(buffer)-4)/4)
+			for i := range providers {
+				providers[i] = binary.BigEndian.Uint32(buffer[4+4*i : 8+4*i])
+			}
+			aspa := ASPA{announcement, customerASN, providers}
+			client.logger().Info(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, fmt.Sprintf("ASPA for customer AS%d, %d providers", customerASN, len(providers)))
+			action(Event{Description: "ASPA", ASPA: &aspa}, *client)
 		case eRRORREPORT:
 			lengthPDU := binary.BigEndian.Uint32(buffer[0:4])
 			lengthText := binary.BigEndian.Uint32(buffer[4+lengthPDU : 8+lengthPDU])
 			errorCode := binary.BigEndian.Uint16(headerbuffer[2:4]) // http://www.iana.org/assignments/rpki/rpki.xml#rpki-rtr-error
 			errorText := string(buffer[8+lengthPDU : 8+lengthPDU+lengthText])
-			comm <- errors.New(fmt.Sprintf("Got an Error Report #%d \"%s\"", errorCode, errorText))
+			action(Event{Description: fmt.Sprintf("Error Report #%d", errorCode), ErrorReport: &ErrorReport{errorCode, errorText}}, *client)
+			if errorCode == unsupportedProtocolVersion && client.version > 0 {
+				client.version--
+				client.logger().Warn(logrus.Fields{"remote": client.remote, "pdu_type": pduType, "version": client.version}, fmt.Sprintf("Cache rejected our protocol version, downgrading to %d and redialing", client.version))
+				comm <- errUnsupportedVersion
+				break
+			}
+			err = errors.New(fmt.Sprintf("Got an Error Report #%d \"%s\"", errorCode, errorText))
+			client.logger().Error(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+			comm <- err
 			break
 		default:
-			comm <- errors.New(fmt.Sprintf("Unknown PDU type %d\n", pduType)) // TODO: what does the RFC says about that?
+			err = errors.New(fmt.Sprintf("Unknown PDU type %d\n", pduType)) // TODO: what does the RFC says about that?
+			client.logger().Error(logrus.Fields{"remote": client.remote, "pdu_type": pduType}, err.Error())
+			comm <- err
 			break
 		}
 	}
@@ -250,7 +562,7 @@ func (client *Client) serialQuery() (err error) {
 		return errors.New("serialQuery called but no serial number known")
 	}
 	serialquery := make([]byte, sERIALQUERYSIZE)
-	serialquery[0] = protocolVersion
+	serialquery[0] = client.version
 	serialquery[1] = sERIALQUERY
 	binary.BigEndian.PutUint16(serialquery[2:4], *client.SessionID)
 	binary.BigEndian.PutUint32(serialquery[4:8], sERIALQUERYSIZE)
@@ -264,7 +576,7 @@ func (client *Client) serialQuery() (err error) {
 
 func (client *Client) resetQuery() (err error) {
 	resetquery := make([]byte, rESETQUERYSIZE)
-	resetquery[0] = protocolVersion
+	resetquery[0] = client.version
 	resetquery[1] = rESETQUERY
 	resetquery[2] = 0 // No need to indicate a real Session ID
 	resetquery[3] = 0
@@ -277,35 +589,203 @@ func (client *Client) resetQuery() (err error) {
 	return err
 }
 
-func (client *Client) loop() (err error) {
-	for over := false; !over; {
-		time.Sleep(sLEEPTIME)
-		err := client.serialQuery()
-		if err != nil {
-			return errors.New("Writing Serial Query failed")
+func (client *Client) loop(ctx context.Context) (err error) {
+	ticker := time.NewTicker(sLEEPTIME)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			client.logger().Debug(logrus.Fields{"remote": client.remote}, "Poll cycle, sending Serial Query")
+			if err := client.serialQuery(); err != nil {
+				return errors.New("Writing Serial Query failed")
+			}
 		}
 	}
-	return nil
 }
 
-// Connect to a RPKI cache and run the provided callback "action" for
-// each prefix we receive. This function will never return except in
-// case of error. If you want to continue even when the cache
-// restarts, you have to loop over Dial()
-func (client *Client) Dial(address string, action func(Event, Client), version int) (err error) {
+// dialOnce performs one or more connection attempts to address and
+// runs until one terminates for a reason other than a protocol
+// version downgrade, either because of a protocol/transport error or
+// because ctx was canceled. It is the shared implementation behind
+// Dial and DialContext.
+//
+// The version argument only seeds client.version on the very first
+// call for this Client: once negotiated, readData drives it down on
+// its own (RFC 8210 §7) and later calls, including reconnects from
+// DialContext, keep using the negotiated value.
+func (client *Client) dialOnce(ctx context.Context, address string, action func(Event, Client), version int) (err error) {
+	if !client.negotiated {
+		client.version = byte(version)
+		client.negotiated = true
+	}
+	for {
+		err = client.connectAndServe(ctx, address, action)
+		if err == errUnsupportedVersion {
+			continue
+		}
+		return err
+	}
+}
+
+// connectAndServe performs a single TCP connection attempt and runs
+// it until it terminates.
+func (client *Client) connectAndServe(ctx context.Context, address string, action func(Event, Client)) (err error) {
 	client.SessionID = nil
 	client.SerialNo = nil
-	protocolVersion = byte(version)
-	client.connection, err = net.Dial("tcp", address)
+	client.remote = address
+	client.sawFullCycle = false
+	client.logger().Info(logrus.Fields{"remote": address, "version": client.version}, "Dialing RTR cache")
+	if client.tlsConfig != nil {
+		tlsDialer := tls.Dialer{Config: client.tlsConfig}
+		client.connection, err = tlsDialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		client.connection, err = dialer.DialContext(ctx, "tcp", address)
+	}
 	if err != nil {
+		client.logger().Error(logrus.Fields{"remote": address}, fmt.Sprintf("Cannot connect: %s", err))
 		return err
 	}
 	defer client.connection.Close()
 	client.resetQuery()
 	// TODO: allow to start with Serial Query (and a given serial number)?
-	errChannel := make(chan error)
-	go client.readData(errChannel, action)
-	go client.loop()
-	status := <-errChannel
-	return status
+	// attemptCtx is scoped to this single connection attempt: it is
+	// canceled both by the caller's ctx and when this attempt ends, so
+	// a redial in DialContext never leaves the previous attempt's
+	// loop() goroutine polling a closed connection.
+	attemptCtx, attemptCancel := context.WithCancel(ctx)
+	defer attemptCancel()
+	// When attemptCtx is canceled (by Close/Shutdown, by the caller,
+	// or because this attempt is ending), unblock the Read that
+	// readData is sitting on so it can notice ctx.Err() and return
+	// cleanly instead of leaking.
+	go func() {
+		<-attemptCtx.Done()
+		client.connection.SetReadDeadline(time.Now())
+	}()
+	errChannel := make(chan error, 1)
+	wg := client.waitGroup()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		client.readData(attemptCtx, errChannel, action)
+	}()
+	go func() {
+		defer wg.Done()
+		client.loop(attemptCtx)
+	}()
+	select {
+	case err = <-errChannel:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+// Connect to a RPKI cache and run the provided callback "action" for
+// each prefix we receive. This function will never return except in
+// case of error. If you want to continue even when the cache
+// restarts, you have to loop over Dial(), or use DialContext, which
+// reconnects automatically. Call Close or Shutdown to stop it
+// cleanly. address can be a literal IPv6 address joined with a port
+// via JoinHostPort. opts currently only accepts WithTLS; the backoff
+// options only affect DialContext.
+func (client *Client) Dial(address string, action func(Event, Client), version int, opts ...DialOption) (err error) {
+	options := defaultDialOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	client.tlsConfig = options.Transport
+	ctx, cancel := context.WithCancel(context.Background())
+	client.cancel = cancel
+	return client.dialOnce(ctx, address, action, version)
+}
+
+// DialContext connects to a RPKI cache like Dial, but keeps the
+// session alive across transient errors: on failure it reconnects
+// with exponential backoff and jitter (see WithBaseDelay, WithFactor,
+// WithMaxDelay, WithJitter, WithMaxRetries). The retry counter is
+// reset whenever a connection manages a full cACHERESPONSE + eNDOFDATA
+// cycle before failing, so a long-lived session that drops once in a
+// while isn't penalized by earlier, unrelated failures. DialContext
+// returns when ctx is canceled, MaxRetries is exhausted, or dialOnce
+// returns a non-transient error while ctx is already done.
+func (client *Client) DialContext(ctx context.Context, address string, action func(Event, Client), version int, opts ...DialOption) (err error) {
+	options := defaultDialOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	client.tlsConfig = options.Transport
+	ctx, cancel := context.WithCancel(ctx)
+	client.cancel = cancel
+	retries := 0
+	for {
+		err = client.dialOnce(ctx, address, action, version)
+		if ctx.Err() != nil {
+			return err
+		}
+		if client.sawFullCycle {
+			retries = 0
+		}
+		if options.MaxRetries > 0 && retries >= options.MaxRetries {
+			return err
+		}
+		delay := options.backoff(retries)
+		retries++
+		client.logger().Warn(logrus.Fields{"remote": address, "retry": retries, "delay": delay.String()}, fmt.Sprintf("Reconnecting after error: %s", err))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Close requests that the client stop and waits for its goroutines to
+// exit. It is equivalent to Shutdown(context.Background()).
+func (client *Client) Close() error {
+	return client.Shutdown(context.Background())
+}
+
+// Shutdown cooperatively stops the goroutines started by Dial or
+// DialContext: it cancels their shared context, which makes loop()
+// return and, via a read deadline set on the connection, unblocks the
+// pending Read in readData() so it can return too. It then waits for
+// both to exit before returning. It is a no-op if called before
+// Dial/DialContext.
+func (client *Client) Shutdown(ctx context.Context) error {
+	if client.cancel == nil {
+		return nil
+	}
+	client.cancel()
+	done := make(chan struct{})
+	go func() {
+		client.waitGroup().Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until the reader and poller goroutines spawned by the
+// current (or most recent) Dial/DialContext call have both exited.
+func (client *Client) Wait() {
+	client.waitGroup().Wait()
+}
+
+// JoinHostPort joins host and port into an address suitable for Dial
+// or DialContext, handling literal IPv6 addresses correctly (unlike
+// plain "host" + ":" + "port" concatenation, which breaks on them).
+// It is a thin wrapper around net.JoinHostPort provided so callers
+// don't need to import "net" just to build the address argument.
+func JoinHostPort(host, port string) string {
+	return net.JoinHostPort(host, port)
 }
@@ -0,0 +1,76 @@
+package rtr
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackoffNoJitter(t *testing.T) {
+	opts := dialOptions{BaseDelay: time.Second, Factor: 2, MaxDelay: 5 * time.Second, Jitter: 0}
+	cases := []struct {
+		retries int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 5 * time.Second}, // capped at MaxDelay
+		{10, 5 * time.Second},
+	}
+	for _, c := range cases {
+		if got := opts.backoff(c.retries); got != c.want {
+			t.Errorf("backoff(%d) = %v, want %v", c.retries, got, c.want)
+		}
+	}
+}
+
+func TestBackoffJitterBounded(t *testing.T) {
+	opts := dialOptions{BaseDelay: time.Second, Factor: 1, MaxDelay: time.Second, Jitter: 0.5}
+	for i := 0; i < 20; i++ {
+		got := opts.backoff(1)
+		if got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("backoff(1) = %v, want within [0.5s, 1.5s]", got)
+		}
+	}
+}
+
+// TestErrorReportDowngradesVersion feeds readData a canned Error
+// Report PDU shaped like a cache's real rejection of an unsupported
+// protocol version: per RFC 8210 §5.10 the PDU header carries the
+// cache's own (lower) version, not the version the client asked for.
+// It must still reach the eRRORREPORT case and trigger a downgrade,
+// rather than being rejected earlier by the header version check.
+func TestErrorReportDowngradesVersion(t *testing.T) {
+	client := &Client{version: 2}
+	server, conn := net.Pipe()
+	defer server.Close()
+	defer conn.Close()
+	client.connection = conn
+
+	pdu := []byte{
+		0, eRRORREPORT, 0, unsupportedProtocolVersion, // header: cache's version, type, error code
+		0, 0, 0, 16, // total PDU length
+		0, 0, 0, 0, // length of the encapsulated PDU (none)
+		0, 0, 0, 0, // length of the error text (none)
+	}
+	go server.Write(pdu)
+
+	comm := make(chan error, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.readData(ctx, comm, func(Event, Client) {})
+
+	select {
+	case err := <-comm:
+		if err != errUnsupportedVersion {
+			t.Fatalf("comm received %v, want errUnsupportedVersion", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the downgrade signal")
+	}
+	if client.version != 1 {
+		t.Errorf("client.version = %d, want 1 (downgraded from 2)", client.version)
+	}
+}
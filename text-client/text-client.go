@@ -4,11 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"github.com/bortzmeyer/GoRTR/rtr"
+	"github.com/sirupsen/logrus"
 	"os"
 	"strconv"
 	"time"
 )
 
+var log = logrus.New()
+
 func display(event rtr.Event, state rtr.Client) {
 	var (
 		announce    string
@@ -43,23 +46,23 @@ func main() {
 	version := 1
 	flag.Parse()
 	if flag.NArg() != 2 && flag.NArg() != 3 {
-		fmt.Printf("Usage: rtrclient server port [version]\n")
+		log.Error("Usage: rtrclient server port [version]")
 		os.Exit(1)
 	}
 	server := flag.Arg(0)
 	port := flag.Arg(1)
-	remote := server + ":" + port /* TODO does it work with IPv6 ? */
+	remote := rtr.JoinHostPort(server, port)
 	if flag.NArg() == 3 {
 		version, err = strconv.Atoi(flag.Arg(2))
 		if err != nil {
-			fmt.Printf("RTR version (you typed \"%s\") must be an integer: %s\n", flag.Arg(2), err)
+			log.WithFields(logrus.Fields{"version": flag.Arg(2)}).Errorf("RTR version must be an integer: %s", err)
 			os.Exit(1)
 		}
 	}
 	rtrClient := &rtr.Client{}
 	err = rtrClient.Dial(remote, display, version)
 	if err != nil {
-		fmt.Printf("%s Problem with RTR server: %s\n", time.Now().Format(time.RFC3339), err)
+		log.WithFields(logrus.Fields{"remote": remote}).Errorf("Problem with RTR server: %s", err)
 		os.Exit(1)
 	}
 }